@@ -0,0 +1,262 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/ryandotsmith/l2met/bucket"
+	"github.com/ryandotsmith/l2met/utils"
+)
+
+// invalidateChannel is the pub/sub channel outlet processes use to tell
+// each other a bucket id's cached values are stale and should be dropped.
+const invalidateChannel = "cached-store.invalidate"
+
+// CachedStore layers a bounded in-memory LRU of recently-written bucket
+// values in front of a RedisStore, so a burst of Puts/Gets for the same
+// bucket within a flush window doesn't round-trip to Redis every time.
+// Writes are batched and flushed to Redis asynchronously; a pub/sub
+// channel lets other CachedStore instances invalidate an entry as soon as
+// one of them scans (consumes) it.
+type CachedStore struct {
+	redis *RedisStore
+
+	maxEntries int
+	ttl        time.Duration
+	flushEvery time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key      string
+	id       *bucket.Id
+	vals     []float64   // merged view of all values seen, for cache reads
+	pending  [][]float64 // Put batches not yet flushed to redis
+	expireAt time.Time
+}
+
+// NewCachedStore wraps rs with an LRU of at most maxEntries buckets, each
+// held for ttl since its last write, flushed to redis every flushEvery.
+func NewCachedStore(rs *RedisStore, maxEntries int, ttl, flushEvery time.Duration) *CachedStore {
+	s := &CachedStore{
+		redis:      rs,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		flushEvery: flushEvery,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	go s.flushLoop()
+	go s.subscribeInvalidations()
+	return s
+}
+
+func (s *CachedStore) MaxPartitions() uint64 {
+	return s.redis.MaxPartitions()
+}
+
+func (s *CachedStore) Health() bool {
+	return s.redis.Health()
+}
+
+// Scan delegates to the underlying RedisStore and, for every bucket it
+// reclaims, evicts it locally and publishes an invalidation so other
+// CachedStore instances don't serve a stale cached copy once this process
+// starts aggregating a fresh window for that id.
+func (s *CachedStore) Scan(schedule time.Time) (<-chan *bucket.Bucket, error) {
+	in, err := s.redis.Scan(schedule)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *bucket.Bucket)
+	go func() {
+		defer close(out)
+		for b := range in {
+			if key, err := b.Id.Encode(); err == nil {
+				s.evict(string(key))
+				s.publishInvalidate(string(key))
+			}
+			out <- b
+		}
+	}()
+	return out, nil
+}
+
+// Put appends b.Vals to the cached aggregate for b.Id and marks it pending
+// flush. It never touches Redis directly; flushLoop does that in batches.
+func (s *CachedStore) Put(b *bucket.Bucket) error {
+	defer utils.MeasureT("cached-bucket.put", time.Now())
+	b.Lock()
+	key, err := b.Id.Encode()
+	vals := append([]float64(nil), b.Vals...)
+	id := b.Id
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[string(key)]
+	if !ok {
+		ent := &cacheEntry{key: string(key), id: id}
+		el = s.order.PushFront(ent)
+		s.entries[string(key)] = el
+	} else {
+		s.order.MoveToFront(el)
+	}
+	ent := el.Value.(*cacheEntry)
+	ent.vals = append(ent.vals, vals...)
+	ent.pending = append(ent.pending, vals)
+	ent.expireAt = time.Now().Add(s.ttl)
+	s.evictOverCapacityLocked()
+	return nil
+}
+
+// Get serves b.Vals from the LRU when present and unexpired, falling back
+// to the wrapped RedisStore (and caching the result) on a miss.
+func (s *CachedStore) Get(b *bucket.Bucket) error {
+	defer utils.MeasureT("cached-bucket.get", time.Now())
+	key, err := b.Id.Encode()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if el, ok := s.entries[string(key)]; ok {
+		ent := el.Value.(*cacheEntry)
+		if time.Now().Before(ent.expireAt) {
+			s.order.MoveToFront(el)
+			b.Vals = append([]float64(nil), ent.vals...)
+			s.mu.Unlock()
+			return nil
+		}
+		s.removeLocked(el)
+	}
+	s.mu.Unlock()
+
+	if err := s.redis.Get(b); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	ent := &cacheEntry{key: string(key), id: b.Id, vals: append([]float64(nil), b.Vals...), expireAt: time.Now().Add(s.ttl)}
+	s.entries[string(key)] = s.order.PushFront(ent)
+	s.evictOverCapacityLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+// evictOverCapacityLocked drops least-recently-used entries until the LRU
+// is back within maxEntries. Callers must hold s.mu.
+func (s *CachedStore) evictOverCapacityLocked() {
+	for s.order.Len() > s.maxEntries {
+		s.removeLocked(s.order.Back())
+	}
+}
+
+// removeLocked drops el from the LRU, flushing any pending writes first so
+// eviction never loses data. Callers must hold s.mu.
+func (s *CachedStore) removeLocked(el *list.Element) {
+	ent := el.Value.(*cacheEntry)
+	if len(ent.pending) > 0 {
+		go s.flushEntry(ent.id, ent.pending)
+	}
+	s.order.Remove(el)
+	delete(s.entries, ent.key)
+}
+
+// evict drops key from the LRU, same as removeLocked: any pending writes
+// are flushed first so a Scan reclaiming a bucket (or another process's
+// invalidation of one) can't race a pending Put and discard it.
+func (s *CachedStore) evict(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.removeLocked(el)
+	}
+}
+
+// flushLoop periodically pushes every entry's pending writes to Redis.
+func (s *CachedStore) flushLoop() {
+	ticker := time.NewTicker(s.flushEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.flush()
+	}
+}
+
+func (s *CachedStore) flush() {
+	s.mu.Lock()
+	type dirty struct {
+		id      *bucket.Id
+		pending [][]float64
+	}
+	var batch []dirty
+	for _, el := range s.entries {
+		ent := el.Value.(*cacheEntry)
+		if len(ent.pending) == 0 {
+			continue
+		}
+		batch = append(batch, dirty{id: ent.id, pending: ent.pending})
+		ent.pending = nil
+	}
+	s.mu.Unlock()
+
+	for _, d := range batch {
+		s.flushEntry(d.id, d.pending)
+	}
+}
+
+func (s *CachedStore) flushEntry(id *bucket.Id, pending [][]float64) {
+	key, err := id.Encode()
+	if err != nil {
+		fmt.Printf("at=%q error=%s\n", "cached-store-flush-encode", err)
+		return
+	}
+	if err := s.redis.putValues(key, pending...); err != nil {
+		fmt.Printf("at=%q error=%s\n", "cached-store-flush", err)
+	}
+}
+
+// publishInvalidate tells other CachedStore instances to drop key from
+// their own LRU.
+func (s *CachedStore) publishInvalidate(key string) {
+	rc, err := s.redis.backend.Get(key)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	rc.Do("PUBLISH", invalidateChannel, key)
+}
+
+// subscribeInvalidations listens for other processes' invalidations and
+// evicts the named key locally. It reconnects on error.
+func (s *CachedStore) subscribeInvalidations() {
+	for {
+		rc, err := s.redis.backend.Get(invalidateChannel)
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+		psc := redis.PubSubConn{Conn: rc}
+		psc.Subscribe(invalidateChannel)
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				s.evict(string(v.Data))
+			case error:
+				rc.Close()
+				goto reconnect
+			}
+		}
+	reconnect:
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"container/list"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/ryandotsmith/l2met/bucket"
+)
+
+// recordingConn records every RPUSH payload it sees and signals flushed
+// once an EXEC runs, so a test can wait for an async flushEntry goroutine
+// to finish instead of polling or sleeping arbitrarily.
+type recordingConn struct {
+	rpushes [][]byte
+	flushed chan struct{}
+}
+
+func (c *recordingConn) Close() error { return nil }
+func (c *recordingConn) Err() error   { return nil }
+
+func (c *recordingConn) Send(cmd string, args ...interface{}) error {
+	if cmd == "RPUSH" {
+		c.rpushes = append(c.rpushes, args[1].([]byte))
+	}
+	return nil
+}
+
+func (c *recordingConn) Flush() error                  { return nil }
+func (c *recordingConn) Receive() (interface{}, error) { return nil, nil }
+
+func (c *recordingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "EXEC" {
+		c.flushed <- struct{}{}
+	}
+	return nil, nil
+}
+
+type recordingBackend struct {
+	conn *recordingConn
+}
+
+func (b *recordingBackend) Get(key string) (redis.Conn, error)        { return b.conn, nil }
+func (b *recordingBackend) ScanKeys(pattern string) ([]string, error) { return nil, nil }
+func (b *recordingBackend) Close() error                              { return nil }
+
+// newTestCachedStore builds a CachedStore with its background flush and
+// invalidation-subscriber goroutines left unstarted, so a test can drive
+// eviction directly without racing a flushLoop tick or a pub/sub fake.
+func newTestCachedStore(rs *RedisStore, flushEvery time.Duration) *CachedStore {
+	return &CachedStore{
+		redis:      rs,
+		maxEntries: 10,
+		ttl:        time.Minute,
+		flushEvery: flushEvery,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// TestCachedStoreEvictFlushesPending pins down the invariant evict relies
+// on removeLocked for: a Put's batch that hasn't reached the next
+// flushLoop tick yet must still make it to Redis when the entry is
+// reclaimed by Scan or invalidated by another process, not dropped on the
+// floor.
+func TestCachedStoreEvictFlushesPending(t *testing.T) {
+	conn := &recordingConn{flushed: make(chan struct{}, 1)}
+	rs := &RedisStore{backend: &recordingBackend{conn: conn}, maxPartitions: 1}
+	cs := newTestCachedStore(rs, time.Hour)
+
+	id := &bucket.Id{Name: "evict-test", Time: time.Unix(0, 0)}
+	if err := cs.Put(&bucket.Bucket{Id: id, Vals: []float64{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Put(&bucket.Bucket{Id: id, Vals: []float64{3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := id.Encode()
+	cs.evict(string(key))
+
+	select {
+	case <-conn.flushed:
+	case <-time.After(time.Second):
+		t.Fatal("evict did not flush pending writes before dropping the entry")
+	}
+
+	if len(conn.rpushes) != 2 {
+		t.Fatalf("expected both pending batches RPUSHed, got %d", len(conn.rpushes))
+	}
+	var got []float64
+	for _, raw := range conn.rpushes {
+		if err := decodeVals(raw, &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []float64{1, 2, 3}
+	if len(got) != len(want) {
+		t.Fatalf("expected=%v actual=%v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("vals[%d]: expected=%f actual=%f", i, want[i], got[i])
+		}
+	}
+
+	cs.mu.Lock()
+	_, stillCached := cs.entries[string(key)]
+	cs.mu.Unlock()
+	if stillCached {
+		t.Error("expected evict to remove the entry from the LRU")
+	}
+}
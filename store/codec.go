@@ -0,0 +1,47 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// valsCodecVersion1 is the little-endian float64 encoding: a 1-byte
+// version prefix followed by 8 bytes per value. It replaces the old
+// text-formatted "[1 2 3]" list, which was slow to parse and ~4x larger
+// on the wire for typical samples.
+const valsCodecVersion1 = 1
+
+// encodeVals serializes vals as a versioned binary payload suitable for
+// storing in a single Redis list element.
+func encodeVals(vals []float64) []byte {
+	buf := make([]byte, 1+8*len(vals))
+	buf[0] = valsCodecVersion1
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(buf[1+8*i:], math.Float64bits(v))
+	}
+	return buf
+}
+
+// decodeVals parses src into dest. It understands the current binary
+// codec and, for one release, falls back to the legacy text encoding so
+// entries written before the upgrade can still be read.
+func decodeVals(src []byte, dest *[]float64) error {
+	if len(src) == 0 {
+		return fmt.Errorf("codec: empty payload")
+	}
+	if src[0] == '[' {
+		return decodeLegacyList(src, dest)
+	}
+	if src[0] != valsCodecVersion1 {
+		return fmt.Errorf("codec: unknown version %d", src[0])
+	}
+	body := src[1:]
+	if len(body)%8 != 0 {
+		return fmt.Errorf("codec: payload length %d is not a multiple of 8", len(body))
+	}
+	for i := 0; i < len(body); i += 8 {
+		*dest = append(*dest, math.Float64frombits(binary.LittleEndian.Uint64(body[i:i+8])))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeValsRoundTrip(t *testing.T) {
+	cases := [][]float64{
+		nil,
+		{0},
+		{99.99999, 1, 0.2},
+		{-1.5, 1e10, -1e-10},
+	}
+	for _, vals := range cases {
+		var got []float64
+		if err := decodeVals(encodeVals(vals), &got); err != nil {
+			t.Fatalf("decodeVals(encodeVals(%v)) returned error: %s", vals, err)
+		}
+		if len(got) != len(vals) {
+			t.Fatalf("expected=%v actual=%v", vals, got)
+		}
+		for i := range vals {
+			if got[i] != vals[i] {
+				t.Errorf("vals[%d]: expected=%f actual=%f", i, vals[i], got[i])
+			}
+		}
+	}
+}
+
+func TestDecodeValsVersionByte(t *testing.T) {
+	var dest []float64
+	err := decodeVals([]byte{valsCodecVersion1 + 1, 0, 0, 0, 0, 0, 0, 0, 0}, &dest)
+	if err == nil {
+		t.Error("expected an error for an unknown version byte")
+	}
+}
+
+func TestDecodeValsMalformedLength(t *testing.T) {
+	var dest []float64
+	err := decodeVals([]byte{valsCodecVersion1, 0, 0, 0}, &dest)
+	if err == nil {
+		t.Error("expected an error for a body length that isn't a multiple of 8")
+	}
+}
+
+func TestDecodeValsEmpty(t *testing.T) {
+	var dest []float64
+	if err := decodeVals(nil, &dest); err == nil {
+		t.Error("expected an error for an empty payload")
+	}
+}
+
+func TestDecodeValsLegacyList(t *testing.T) {
+	var dest []float64
+	if err := decodeVals([]byte("[1 2.5 3]"), &dest); err != nil {
+		t.Fatalf("decodeVals returned error: %s", err)
+	}
+	want := []float64{1, 2.5, 3}
+	if len(dest) != len(want) {
+		t.Fatalf("expected=%v actual=%v", want, dest)
+	}
+	for i := range want {
+		if dest[i] != want[i] {
+			t.Errorf("vals[%d]: expected=%f actual=%f", i, want[i], dest[i])
+		}
+	}
+}
+
+func TestEncodeValsStartsWithCurrentVersion(t *testing.T) {
+	buf := encodeVals([]float64{1})
+	if !bytes.Equal(buf[:1], []byte{valsCodecVersion1}) {
+		t.Errorf("expected version byte %d, got %d", valsCodecVersion1, buf[0])
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryandotsmith/l2met/bucket"
+)
+
+// fakeMultiConn is a minimal in-memory redis.Conn covering just what
+// fetchBatch needs: MULTI/EXEC pipelining of LRANGE against a fixed
+// key->list-of-entries map. It isn't a general-purpose fake Redis.
+type fakeMultiConn struct {
+	lists map[string][][]byte
+	queue [][]interface{}
+}
+
+func (c *fakeMultiConn) Close() error { return nil }
+func (c *fakeMultiConn) Err() error   { return nil }
+
+func (c *fakeMultiConn) Send(cmd string, args ...interface{}) error {
+	if cmd == "MULTI" {
+		return nil
+	}
+	c.queue = append(c.queue, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *fakeMultiConn) Flush() error                  { return nil }
+func (c *fakeMultiConn) Receive() (interface{}, error) { return nil, nil }
+
+func (c *fakeMultiConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "EXEC" {
+		return nil, nil
+	}
+	results := make([]interface{}, len(c.queue))
+	for i, q := range c.queue {
+		results[i] = c.lrange(q)
+	}
+	c.queue = nil
+	return results, nil
+}
+
+func (c *fakeMultiConn) lrange(q []interface{}) interface{} {
+	key := string(q[1].([]byte))
+	entries := c.lists[key]
+	out := make([]interface{}, len(entries))
+	for i, e := range entries {
+		out[i] = e
+	}
+	return out
+}
+
+func TestFetchBatchDecodesMultipleEntriesPerKey(t *testing.T) {
+	partition := "partition.outlet.lock.0"
+	id1 := &bucket.Id{Name: "bucket-one", Time: time.Unix(0, 0)}
+	id2 := &bucket.Id{Name: "bucket-two", Time: time.Unix(0, 0)}
+	key1, err := id1.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &fakeMultiConn{lists: map[string][][]byte{
+		string(storageKey(partition, key1)): {
+			encodeVals([]float64{1, 2}),
+			encodeVals([]float64{3}),
+		},
+		// key2 has no entries, as if its partition set membership was
+		// stale and the bucket was never actually written.
+	}}
+
+	out := make(chan *bucket.Bucket, 2)
+	s := &RedisStore{}
+	s.fetchBatch(conn, partition, []*bucket.Id{id1, id2}, out)
+	close(out)
+
+	var got []*bucket.Bucket
+	for b := range out {
+		got = append(got, b)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected=1 bucket actual=%d", len(got))
+	}
+	want := []float64{1, 2, 3}
+	if len(got[0].Vals) != len(want) {
+		t.Fatalf("expected vals=%v actual=%v", want, got[0].Vals)
+	}
+	for i := range want {
+		if got[0].Vals[i] != want[i] {
+			t.Errorf("vals[%d]: expected=%f actual=%f", i, want[i], got[0].Vals[i])
+		}
+	}
+	if got[0].Id.Name != id1.Name {
+		t.Errorf("expected id %q, got %q", id1.Name, got[0].Id.Name)
+	}
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// outletRingVnodes is how many points each live outlet gets on the ring.
+// More virtual nodes spread a given outlet's owned partitions more evenly
+// across the ring, at the cost of a larger sorted point list to search.
+const outletRingVnodes = 160
+
+// partitionRing is a consistent-hash ring over the set of live outlet
+// workers. lockPartition uses it to find the partitions this outlet owns
+// instead of probing every partition against every worker, so raising
+// maxPartitions doesn't turn into a thundering herd as outlet count grows.
+type partitionRing struct {
+	mu     sync.RWMutex
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	id   string
+}
+
+func newPartitionRing() *partitionRing {
+	return &partitionRing{}
+}
+
+// rebuild replaces the ring's membership. refreshRing calls it with the
+// set of live outlets (per their heartbeat keys) on every refresh.
+func (r *partitionRing) rebuild(members []string) {
+	points := make([]ringPoint, 0, len(members)*outletRingVnodes)
+	for _, id := range members {
+		for v := 0; v < outletRingVnodes; v++ {
+			points = append(points, ringPoint{hash: ringHash(fmt.Sprintf("%s#%d", id, v)), id: id})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	r.mu.Lock()
+	r.points = points
+	r.mu.Unlock()
+}
+
+// owner returns the id of the live outlet that owns partition p, or "" if
+// the ring has no members yet.
+func (r *partitionRing) owner(p uint64) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(fmt.Sprintf("partition.%d", p))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].id
+}
+
+// ringHash hashes s down to a uint64 ring position. crc64 (used elsewhere
+// in this package for bucketPartition, where collisions across a fixed
+// mod-N range are harmless) isn't a good fit here: it barely mixes short,
+// near-identical inputs like "outlet-a#0"/"outlet-b#0", so vnodes for
+// different outlets land on almost the same point and the ring stops
+// spreading ownership evenly. SHA-1 mixes enough to keep vnodes well
+// spread; only the first 8 bytes of the digest are kept.
+func ringHash(s string) uint64 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,87 @@
+package store
+
+import "testing"
+
+func TestPartitionRingOwnerEmpty(t *testing.T) {
+	r := newPartitionRing()
+	if owner := r.owner(0); owner != "" {
+		t.Errorf("expected no owner before rebuild, got %q", owner)
+	}
+}
+
+func TestPartitionRingOwnerIsStableMember(t *testing.T) {
+	r := newPartitionRing()
+	members := []string{"outlet-a", "outlet-b", "outlet-c"}
+	r.rebuild(members)
+
+	for p := uint64(0); p < 100; p++ {
+		owner := r.owner(p)
+		found := false
+		for _, m := range members {
+			if owner == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("partition %d owned by %q, which isn't a ring member", p, owner)
+		}
+	}
+}
+
+func TestPartitionRingOwnerIsDeterministic(t *testing.T) {
+	r := newPartitionRing()
+	r.rebuild([]string{"outlet-a", "outlet-b", "outlet-c"})
+
+	for p := uint64(0); p < 100; p++ {
+		first := r.owner(p)
+		if second := r.owner(p); second != first {
+			t.Errorf("partition %d: owner changed across calls without a rebuild: %q then %q", p, first, second)
+		}
+	}
+}
+
+func TestPartitionRingRebuildSpreadsOwnership(t *testing.T) {
+	r := newPartitionRing()
+	members := []string{"outlet-a", "outlet-b", "outlet-c"}
+	r.rebuild(members)
+
+	counts := make(map[string]int, len(members))
+	const partitions = 1000
+	for p := uint64(0); p < partitions; p++ {
+		counts[r.owner(p)]++
+	}
+	for _, m := range members {
+		if counts[m] == 0 {
+			t.Errorf("outlet %q owns no partitions out of %d", m, partitions)
+		}
+	}
+}
+
+func TestPartitionRingLosingMemberReassignsOnlyItsShare(t *testing.T) {
+	r := newPartitionRing()
+	members := []string{"outlet-a", "outlet-b", "outlet-c"}
+	r.rebuild(members)
+
+	const partitions = 1000
+	before := make([]string, partitions)
+	for p := uint64(0); p < partitions; p++ {
+		before[p] = r.owner(p)
+	}
+
+	r.rebuild([]string{"outlet-a", "outlet-b"})
+
+	moved := 0
+	for p := uint64(0); p < partitions; p++ {
+		after := r.owner(p)
+		if after == "outlet-c" {
+			t.Fatalf("partition %d still owned by removed outlet-c", p)
+		}
+		if before[p] != "outlet-c" && before[p] != after {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("expected only outlet-c's partitions to move, but %d partitions owned by a and b also moved", moved)
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/ryandotsmith/l2met/utils"
+)
+
+// rateLimitScript is an atomic INCR+EXPIRE so a key's counter and its TTL
+// are set in one round trip, closing the TOCTOU window a plain
+// INCR-then-EXPIRE pair would leave open under concurrent callers. KEYS[1]
+// is the counter key, ARGV[1] the window in seconds, ARGV[2] the limit. It
+// returns {allowed (0/1), current count, seconds to reset}. redis.Script
+// takes care of EVALSHA with a SCRIPT LOAD fallback on NOSCRIPT.
+var rateLimitScript = redis.NewScript(1, `
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+local ttl = redis.call("TTL", KEYS[1])
+local allowed = 1
+if count > tonumber(ARGV[2]) then
+	allowed = 0
+end
+return {allowed, count, ttl}
+`)
+
+// RateLimiter enforces per-key request limits shared across every l2met
+// receiver instance, backed by a single Redis counter per key and window.
+// It's a fixed-window counter: each Allow call bumps a counter for the
+// key's current window and compares it to limit, all inside the
+// Lua script above so the check-then-increment race can't be exploited.
+type RateLimiter struct {
+	backend RedisBackend
+}
+
+// NewRateLimiter builds a RateLimiter against cfg's Redis deployment.
+func NewRateLimiter(cfg RedisConfig) *RateLimiter {
+	return &RateLimiter{backend: newRedisBackend(cfg)}
+}
+
+// Allow reports whether another request for key may proceed under limit
+// requests per window. retryAfter is the time remaining until the current
+// window resets; it's only meaningful when allowed is false.
+func (r *RateLimiter) Allow(key string, limit int, window time.Duration) (allowed bool, retryAfter time.Duration, err error) {
+	defer utils.MeasureT("rate-limiter.allow", time.Now())
+
+	rc, err := r.backend.Get(key)
+	if err != nil {
+		return false, 0, err
+	}
+	defer rc.Close()
+
+	seconds := int(window.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	reply, err := redis.Values(rateLimitScript.Do(rc, key, seconds, limit))
+	if err != nil {
+		return false, 0, err
+	}
+
+	var allowedFlag, count, ttl int64
+	if _, err := redis.Scan(reply, &allowedFlag, &count, &ttl); err != nil {
+		return false, 0, err
+	}
+	return allowedFlag == 1, time.Duration(ttl) * time.Second, nil
+}
+
+// RateLimitKey builds a per-user, per-minute key for Allow, of the form
+// `ratelimit.<user>.<minute>`, where minute is at's Unix time divided by 60.
+func RateLimitKey(user string, at time.Time) string {
+	return fmt.Sprintf("ratelimit.%s.%d", user, at.Unix()/60)
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeScriptConn simulates just enough of the rateLimitScript's behavior
+// (INCR a per-key counter, EXPIRE it on first INCR of a window, report TTL)
+// to exercise RateLimiter.Allow without a live Redis. redis.Script always
+// tries EVALSHA first; reporting NOSCRIPT on every call forces the EVAL
+// fallback every time, which is fine since this fake has no script cache
+// of its own to miss.
+type fakeScriptConn struct {
+	mu sync.Mutex
+
+	count     int64
+	expiresAt time.Time
+}
+
+func (c *fakeScriptConn) Close() error                      { return nil }
+func (c *fakeScriptConn) Err() error                        { return nil }
+func (c *fakeScriptConn) Send(string, ...interface{}) error { return nil }
+func (c *fakeScriptConn) Flush() error                      { return nil }
+func (c *fakeScriptConn) Receive() (interface{}, error)     { return nil, nil }
+
+func (c *fakeScriptConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "EVALSHA":
+		return nil, redis.Error("NOSCRIPT No matching script")
+	case "EVAL":
+		return c.eval(args)
+	default:
+		return nil, nil
+	}
+}
+
+// eval args are [src, keyCount, key, seconds, limit], matching how
+// redis.Script.Do assembles EVAL's argument list for rateLimitScript.
+func (c *fakeScriptConn) eval(args []interface{}) (interface{}, error) {
+	seconds := args[3].(int)
+	limit := args[4].(int)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.expiresAt) {
+		c.count = 0
+		c.expiresAt = now.Add(time.Duration(seconds) * time.Second)
+	}
+	c.count++
+
+	allowed := int64(1)
+	if c.count > int64(limit) {
+		allowed = 0
+	}
+	ttl := int64(c.expiresAt.Sub(now).Seconds())
+	return []interface{}{allowed, c.count, ttl}, nil
+}
+
+type fakeScriptBackend struct {
+	conn *fakeScriptConn
+}
+
+func (b *fakeScriptBackend) Get(key string) (redis.Conn, error)        { return b.conn, nil }
+func (b *fakeScriptBackend) ScanKeys(pattern string) ([]string, error) { return nil, nil }
+func (b *fakeScriptBackend) Close() error                              { return nil }
+
+func newTestRateLimiter() *RateLimiter {
+	return &RateLimiter{backend: &fakeScriptBackend{conn: &fakeScriptConn{}}}
+}
+
+func TestRateLimiterAllowsUnderLimit(t *testing.T) {
+	r := newTestRateLimiter()
+	for i := 0; i < 3; i++ {
+		allowed, _, err := r.Allow("k", 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("request %d: expected allowed", i)
+		}
+	}
+}
+
+func TestRateLimiterDeniesOverLimit(t *testing.T) {
+	r := newTestRateLimiter()
+	for i := 0; i < 3; i++ {
+		if _, _, err := r.Allow("k", 3, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowed, retryAfter, err := r.Allow("k", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected the 4th request over a limit of 3 to be denied")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("expected a positive retryAfter once denied, got %s", retryAfter)
+	}
+}
+
+func TestRateLimiterResetsAfterWindow(t *testing.T) {
+	r := newTestRateLimiter()
+	for i := 0; i < 2; i++ {
+		if _, _, err := r.Allow("k", 2, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allowed, _, _ := r.Allow("k", 2, time.Second); allowed {
+		t.Fatal("expected the 3rd request within the window to be denied")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	allowed, _, err := r.Allow("k", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected a fresh window to allow again")
+	}
+}
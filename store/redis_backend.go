@@ -0,0 +1,464 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisBackend abstracts how RedisStore obtains a connection to talk to
+// Redis. It lets RedisStore stay oblivious to whether it is pointed at a
+// single node, a Sentinel-monitored master, or a Cluster deployment.
+type RedisBackend interface {
+	// Get returns a connection suitable for operating on key. For
+	// backends that don't route by key (single node, Sentinel), key is
+	// ignored.
+	Get(key string) (redis.Conn, error)
+	// ScanKeys returns every key matching pattern across the whole
+	// backend, not just whatever single node a Get-routed connection
+	// happens to land on. SCAN is node-local on a real Cluster, so
+	// discovery scans (e.g. the outlet heartbeat ring) have to go through
+	// this instead of SCANning over a Get(key) connection, or they'd only
+	// ever see the keys living on one node.
+	ScanKeys(pattern string) ([]string, error)
+	Close() error
+}
+
+// scanKeys runs a single SCAN cursor loop against conn, matching pattern,
+// and returns every key found. It's the shared loop body every backend's
+// ScanKeys uses, whether it runs once (single node, Sentinel) or once per
+// node (Cluster).
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		var found []string
+		if _, err := redis.Scan(reply, &cursor, &found); err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+		if cursor == "0" {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// RedisConfig describes how RedisStore should reach its backing Redis.
+// Set exactly one of Sentinels or ClusterNodes to opt into that topology;
+// leaving both empty falls back to a single Server/Pass node.
+type RedisConfig struct {
+	Server string
+	Pass   string
+
+	// Sentinels are host:port addresses of the Sentinel processes
+	// tracking MasterName. When set, the backend discovers the current
+	// master and rebuilds its pool on failover.
+	Sentinels  []string
+	MasterName string
+
+	// ClusterNodes are seed host:port addresses for a Redis Cluster.
+	// When set, the backend routes each command to the node owning the
+	// key's hash slot.
+	ClusterNodes []string
+}
+
+func newRedisBackend(cfg RedisConfig) RedisBackend {
+	switch {
+	case len(cfg.ClusterNodes) > 0:
+		return newClusterBackend(cfg.ClusterNodes, cfg.Pass)
+	case len(cfg.Sentinels) > 0:
+		return newSentinelBackend(cfg.Sentinels, cfg.MasterName, cfg.Pass)
+	default:
+		return &singleNodeBackend{pool: initRedisPool(cfg.Server, cfg.Pass)}
+	}
+}
+
+// singleNodeBackend talks to one Redis server. It is the backend used when
+// l2met is pointed at a plain `redis://` server, same as before this
+// package grew Sentinel and Cluster support.
+type singleNodeBackend struct {
+	pool *redis.Pool
+}
+
+func (b *singleNodeBackend) Get(key string) (redis.Conn, error) {
+	return b.pool.Get(), nil
+}
+
+func (b *singleNodeBackend) ScanKeys(pattern string) ([]string, error) {
+	c := b.pool.Get()
+	defer c.Close()
+	return scanKeys(c, pattern)
+}
+
+func (b *singleNodeBackend) Close() error {
+	return b.pool.Close()
+}
+
+// sentinelBackend discovers the current master for MasterName via a set of
+// Sentinel processes and rebuilds its pool whenever Sentinel announces a
+// failover.
+type sentinelBackend struct {
+	sentinels  []string
+	masterName string
+	pass       string
+
+	mu   sync.RWMutex
+	pool *redis.Pool
+}
+
+func newSentinelBackend(sentinels []string, masterName, pass string) *sentinelBackend {
+	b := &sentinelBackend{
+		sentinels:  sentinels,
+		masterName: masterName,
+		pass:       pass,
+	}
+	b.rebuild()
+	go b.watch()
+	return b
+}
+
+func (b *sentinelBackend) Get(key string) (redis.Conn, error) {
+	b.mu.RLock()
+	pool := b.pool
+	b.mu.RUnlock()
+	if pool == nil {
+		return nil, errors.New("sentinel_backend: no master available")
+	}
+	return pool.Get(), nil
+}
+
+func (b *sentinelBackend) ScanKeys(pattern string) ([]string, error) {
+	b.mu.RLock()
+	pool := b.pool
+	b.mu.RUnlock()
+	if pool == nil {
+		return nil, errors.New("sentinel_backend: no master available")
+	}
+	c := pool.Get()
+	defer c.Close()
+	return scanKeys(c, pattern)
+}
+
+func (b *sentinelBackend) Close() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.pool == nil {
+		return nil
+	}
+	return b.pool.Close()
+}
+
+// masterAddr asks each known Sentinel, in turn, for the current master
+// address, returning the first answer that succeeds.
+func (b *sentinelBackend) masterAddr() (string, error) {
+	var lastErr error
+	for _, addr := range b.sentinels {
+		c, err := redis.Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", b.masterName))
+		c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(reply) != 2 {
+			lastErr = fmt.Errorf("sentinel_backend: unexpected reply %v", reply)
+			continue
+		}
+		return reply[0] + ":" + reply[1], nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("sentinel_backend: no sentinels reachable")
+	}
+	return "", lastErr
+}
+
+func (b *sentinelBackend) rebuild() {
+	addr, err := b.masterAddr()
+	if err != nil {
+		fmt.Printf("at=%q error=%s\n", "sentinel-backend-rebuild", err)
+		return
+	}
+	newPool := initRedisPool(addr, b.pass)
+	b.mu.Lock()
+	oldPool := b.pool
+	b.pool = newPool
+	b.mu.Unlock()
+	if oldPool != nil {
+		oldPool.Close()
+	}
+}
+
+// watch subscribes to Sentinel's +switch-master channel and rebuilds the
+// pool whenever a failover is announced. It reconnects to the first
+// reachable sentinel if the subscription connection drops.
+func (b *sentinelBackend) watch() {
+	for {
+		if len(b.sentinels) == 0 {
+			return
+		}
+		var c redis.Conn
+		var err error
+		for _, addr := range b.sentinels {
+			if c, err = redis.Dial("tcp", addr); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Printf("at=%q error=%s\n", "sentinel-backend-watch", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		psc := redis.PubSubConn{Conn: c}
+		psc.Subscribe("+switch-master")
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				if string(v.Channel) == "+switch-master" {
+					b.rebuild()
+				}
+			case error:
+				c.Close()
+				goto reconnect
+			}
+		}
+	reconnect:
+		time.Sleep(time.Second)
+	}
+}
+
+// clusterBackend routes commands to the Redis Cluster node owning each
+// key's hash slot, refreshing the slot map via CLUSTER SLOTS as nodes move.
+type clusterBackend struct {
+	seeds []string
+	pass  string
+
+	mu     sync.RWMutex
+	pools  map[string]*redis.Pool // addr -> pool
+	ranges []slotRange
+}
+
+type slotRange struct {
+	start, end int
+	addr       string
+}
+
+func newClusterBackend(seeds []string, pass string) *clusterBackend {
+	b := &clusterBackend{
+		seeds: seeds,
+		pass:  pass,
+		pools: make(map[string]*redis.Pool),
+	}
+	b.refreshSlots()
+	go b.refreshLoop()
+	return b
+}
+
+func (b *clusterBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.pools {
+		p.Close()
+	}
+	return nil
+}
+
+func (b *clusterBackend) refreshLoop() {
+	for {
+		time.Sleep(10 * time.Second)
+		b.refreshSlots()
+	}
+}
+
+// refreshSlots asks the first reachable seed for CLUSTER SLOTS and rebuilds
+// the slot -> node mapping from the reply.
+func (b *clusterBackend) refreshSlots() {
+	for _, seed := range b.seeds {
+		c, err := redis.Dial("tcp", seed)
+		if err != nil {
+			continue
+		}
+		reply, err := redis.Values(c.Do("CLUSTER", "SLOTS"))
+		c.Close()
+		if err != nil {
+			continue
+		}
+		ranges := make([]slotRange, 0, len(reply))
+		for _, slotRaw := range reply {
+			slot, err := redis.Values(slotRaw, nil)
+			if err != nil || len(slot) < 3 {
+				continue
+			}
+			start, _ := redis.Int(slot[0], nil)
+			end, _ := redis.Int(slot[1], nil)
+			node, err := redis.Values(slot[2], nil)
+			if err != nil || len(node) < 2 {
+				continue
+			}
+			host, _ := redis.String(node[0], nil)
+			port, _ := redis.Int(node[1], nil)
+			ranges = append(ranges, slotRange{start: start, end: end, addr: fmt.Sprintf("%s:%d", host, port)})
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+		b.mu.Lock()
+		b.ranges = ranges
+		for _, r := range ranges {
+			if _, ok := b.pools[r.addr]; !ok {
+				b.pools[r.addr] = initRedisPool(r.addr, b.pass)
+			}
+		}
+		b.mu.Unlock()
+		return
+	}
+	fmt.Printf("at=%q error=%q\n", "cluster-backend-refresh", "no seed reachable")
+}
+
+func (b *clusterBackend) addrForSlot(slot int) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, r := range b.ranges {
+		if slot >= r.start && slot <= r.end {
+			return r.addr, true
+		}
+	}
+	return "", false
+}
+
+func (b *clusterBackend) poolFor(addr string) *redis.Pool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pools[addr]
+	if !ok {
+		p = initRedisPool(addr, b.pass)
+		b.pools[addr] = p
+	}
+	return p
+}
+
+// ScanKeys fans out a SCAN over every node currently in the slot map and
+// concatenates their results, since a Cluster SCAN only ever iterates the
+// keyspace of the one node it's issued against.
+func (b *clusterBackend) ScanKeys(pattern string) ([]string, error) {
+	b.mu.RLock()
+	addrs := make(map[string]bool, len(b.ranges))
+	for _, r := range b.ranges {
+		addrs[r.addr] = true
+	}
+	b.mu.RUnlock()
+
+	var keys []string
+	for addr := range addrs {
+		c := b.poolFor(addr).Get()
+		found, err := scanKeys(c, pattern)
+		c.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cluster_backend: scan %s: %s", addr, err)
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
+
+// Get returns a connection to the node owning key's hash slot, following
+// MOVED/ASK redirects transparently.
+func (b *clusterBackend) Get(key string) (redis.Conn, error) {
+	slot := keyHashSlot(key)
+	addr, ok := b.addrForSlot(slot)
+	if !ok {
+		b.refreshSlots()
+		addr, ok = b.addrForSlot(slot)
+		if !ok {
+			return nil, fmt.Errorf("cluster_backend: no owner for slot %d", slot)
+		}
+	}
+	return &clusterConn{Conn: b.poolFor(addr).Get(), backend: b}, nil
+}
+
+// clusterConn wraps a single node's connection and transparently follows a
+// one-hop MOVED/ASK redirect, refreshing the slot map on MOVED so the next
+// call routes directly.
+type clusterConn struct {
+	redis.Conn
+	backend *clusterBackend
+}
+
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	if err == nil {
+		return reply, nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "MOVED"):
+		c.backend.refreshSlots()
+		addr := strings.Fields(msg)[2]
+		redirected := c.backend.poolFor(addr).Get()
+		defer redirected.Close()
+		return redirected.Do(cmd, args...)
+	case strings.HasPrefix(msg, "ASK"):
+		addr := strings.Fields(msg)[2]
+		redirected := c.backend.poolFor(addr).Get()
+		defer redirected.Close()
+		redirected.Do("ASKING")
+		return redirected.Do(cmd, args...)
+	default:
+		return reply, err
+	}
+}
+
+// keyHashSlot computes the Redis Cluster slot for key, honoring the
+// {hashtag} convention so multi-key operations can be colocated.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			tag := key[start+1 : start+1+end]
+			if len(tag) > 0 {
+				key = tag
+			}
+		}
+	}
+	return int(crc16(key)) % 16384
+}
+
+var crc16Table = makeCRC16Table()
+
+// makeCRC16Table builds the CRC16/XMODEM table Redis Cluster uses for
+// slot hashing (polynomial 0x1021, initial value 0).
+func makeCRC16Table() [256]uint16 {
+	var table [256]uint16
+	const poly = 0x1021
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = (crc << 8) ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
@@ -0,0 +1,75 @@
+package store
+
+import "testing"
+
+// Known CRC16/XMODEM vectors, same ones the Redis Cluster spec uses to
+// validate slot hashing implementations.
+func TestCRC16KnownVectors(t *testing.T) {
+	cases := []struct {
+		in   string
+		want uint16
+	}{
+		{"", 0x0000},
+		{"123456789", 0x31C3},
+	}
+	for _, c := range cases {
+		if got := crc16(c.in); got != c.want {
+			t.Errorf("crc16(%q) = 0x%04X, want 0x%04X", c.in, got, c.want)
+		}
+	}
+}
+
+func TestKeyHashSlotHonorsHashTag(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"{partition.outlet.lock.3}bucket-one", "{partition.outlet.lock.3}bucket-two"},
+		{"partition.outlet.lock.3", "{partition.outlet.lock.3}anything"},
+		{"{}literal-braces-key", "{}literal-braces-key"},
+	}
+	for _, c := range cases {
+		sa, sb := keyHashSlot(c.a), keyHashSlot(c.b)
+		if sa != sb {
+			t.Errorf("keyHashSlot(%q)=%d and keyHashSlot(%q)=%d should be equal", c.a, sa, c.b, sb)
+		}
+	}
+}
+
+func TestKeyHashSlotRange(t *testing.T) {
+	keys := []string{"", "a", "partition.outlet.lock.0", "{tag}key", "outlets.alive.worker-1"}
+	for _, k := range keys {
+		if slot := keyHashSlot(k); slot < 0 || slot >= 16384 {
+			t.Errorf("keyHashSlot(%q) = %d, out of the [0,16384) slot range", k, slot)
+		}
+	}
+}
+
+// TestStorageKeySharesSlotWithPartition pins down the invariant putValues,
+// Get, and fetchBatch all rely on: tagging a bucket's storage key with its
+// partition name (storageKey) always lands it on the same Cluster slot as
+// the bare partition key, regardless of what bytes the bucket's own key
+// contains. A regression here would silently reopen the CROSSSLOT failures
+// chunk0-1 was fixed to avoid.
+func TestStorageKeySharesSlotWithPartition(t *testing.T) {
+	partitions := []string{
+		"partition.outlet.lock.0",
+		"partition.outlet.lock.17",
+		"partition.outlet.lock.4096",
+	}
+	keys := [][]byte{
+		[]byte(""),
+		[]byte("a"),
+		[]byte("\x00\x01\x02binarybucketid"),
+		[]byte("{contains-braces}"),
+	}
+	for _, partition := range partitions {
+		want := keyHashSlot(partition)
+		for _, key := range keys {
+			skey := storageKey(partition, key)
+			if got := keyHashSlot(string(skey)); got != want {
+				t.Errorf("keyHashSlot(storageKey(%q, %q)) = %d, want %d (partition's own slot)",
+					partition, key, got, want)
+			}
+		}
+	}
+}
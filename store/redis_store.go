@@ -11,9 +11,23 @@ import (
 	"hash/crc64"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// defaultFetchBatchSize bounds how many LRANGE calls ScanAndFetch pipelines
+// into a single MULTI/EXEC, so a large partition doesn't buffer an
+// unbounded number of replies in memory at once.
+const defaultFetchBatchSize = 50
+
+// outletAlivePrefix namespaces the heartbeat keys outlets register under
+// so lockPartition's consistent-hash ring knows who else is alive.
+const outletAlivePrefix = "outlets.alive"
+
+// outletHeartbeatTTL is how long an outlet's heartbeat key lives without a
+// refresh before it's considered gone and its partitions are reassigned.
+const outletHeartbeatTTL = 30 * time.Second
+
 var lockPrefix, partitionPrefix string
 
 func init() {
@@ -24,15 +38,97 @@ func init() {
 var partitionTable = crc64.MakeTable(crc64.ISO)
 
 type RedisStore struct {
-	redisPool     *redis.Pool
+	backend       RedisBackend
 	maxPartitions uint64
+
+	// outletMu guards outletID and ring, which RegisterOutlet sets once
+	// and ownedPartitions reads from lockPartition's caller goroutine.
+	// Until RegisterOutlet is called, ring is nil and lockPartition
+	// falls back to probing every partition.
+	outletMu sync.RWMutex
+	outletID string
+	ring     *partitionRing
 }
 
-func NewRedisStore(server, pass string, maxPartitions uint64) *RedisStore {
+// NewRedisStore builds a RedisStore against whatever topology cfg
+// describes: a single node, a Sentinel-monitored master, or a Cluster.
+func NewRedisStore(cfg RedisConfig, maxPartitions uint64) *RedisStore {
 	return &RedisStore{
 		maxPartitions: maxPartitions,
-		redisPool:     initRedisPool(server, pass),
+		backend:       newRedisBackend(cfg),
+	}
+}
+
+// RegisterOutlet opts this store into consistent-hash partition
+// assignment: it heartbeats id under outlets.alive.<id> and keeps a ring
+// of every live outlet in sync, so lockPartition only contends for the
+// partitions this outlet owns rather than racing every worker against
+// every partition as maxPartitions grows. Calling it more than once on
+// the same store is a no-op after the first call.
+func (s *RedisStore) RegisterOutlet(id string) {
+	s.outletMu.Lock()
+	if s.ring != nil {
+		s.outletMu.Unlock()
+		return
+	}
+	s.outletID = id
+	s.ring = newPartitionRing()
+	s.outletMu.Unlock()
+
+	go s.runEvery(outletHeartbeatTTL/3, s.heartbeat)
+	go s.runEvery(outletHeartbeatTTL/3, s.refreshRing)
+}
+
+// runEvery calls fn immediately, then again every interval, for as long as
+// the process runs. RegisterOutlet runs both heartbeat and refreshRing
+// through it.
+func (s *RedisStore) runEvery(interval time.Duration, fn func()) {
+	fn()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		fn()
+	}
+}
+
+func (s *RedisStore) heartbeat() {
+	s.outletMu.RLock()
+	id := s.outletID
+	s.outletMu.RUnlock()
+
+	rc, err := s.backend.Get(id)
+	if err != nil {
+		fmt.Printf("at=%q error=%s\n", "outlet-heartbeat", err)
+		return
 	}
+	defer rc.Close()
+	key := outletAlivePrefix + "." + id
+	if _, err := rc.Do("SET", key, 1, "EX", int(outletHeartbeatTTL.Seconds())); err != nil {
+		fmt.Printf("at=%q error=%s\n", "outlet-heartbeat", err)
+	}
+}
+
+// refreshRing scans for live outlets.alive.* keys and rebuilds the ring
+// from them on every call, whether or not membership changed. It goes through
+// backend.ScanKeys rather than SCANning over a single Get-routed
+// connection, because under clusterBackend a plain SCAN only covers the
+// one node that connection happens to land on and would silently drop
+// every outlet whose heartbeat key hashed to a different node.
+func (s *RedisStore) refreshRing() {
+	s.outletMu.RLock()
+	ring := s.ring
+	s.outletMu.RUnlock()
+
+	keys, err := s.backend.ScanKeys(outletAlivePrefix + ".*")
+	if err != nil {
+		fmt.Printf("at=%q error=%s\n", "outlet-ring-refresh", err)
+		return
+	}
+	members := make([]string, len(keys))
+	for i, key := range keys {
+		members[i] = strings.TrimPrefix(key, outletAlivePrefix+".")
+	}
+	ring.rebuild(members)
 }
 
 func initRedisPool(server, pass string) *redis.Pool {
@@ -59,9 +155,12 @@ func (s *RedisStore) MaxPartitions() uint64 {
 }
 
 func (s *RedisStore) Health() bool {
-	rc := s.redisPool.Get()
+	rc, err := s.backend.Get(lockPrefix)
+	if err != nil {
+		return false
+	}
 	defer rc.Close()
-	_, err := rc.Do("PING")
+	_, err = rc.Do("PING")
 	if err != nil {
 		return false
 	}
@@ -70,17 +169,31 @@ func (s *RedisStore) Health() bool {
 
 func (s *RedisStore) Scan(schedule time.Time) (<-chan *bucket.Bucket, error) {
 	retBuckets := make(chan *bucket.Bucket)
-	rc := s.redisPool.Get()
+	rc, err := s.backend.Get(lockPrefix)
+	if err != nil {
+		return nil, err
+	}
 	mut := s.lockPartition(rc)
-	partition := partitionPrefix  + "." + mut.Name
+	partition := partitionPrefix + "." + mut.Name
+	// SMEMBERS/DEL below run on partition, a different key than whatever
+	// lockPrefix happened to route rc to above; get a connection pinned
+	// to partition's own slot so the MULTI/EXEC is single-slot under
+	// clusterBackend.
+	prc, err := s.backend.Get(partition)
+	if err != nil {
+		rc.Close()
+		mut.Unlock(rc)
+		return nil, err
+	}
 	go func(out chan *bucket.Bucket) {
 		defer rc.Close()
+		defer prc.Close()
 		defer mut.Unlock(rc)
 		defer close(out)
-		rc.Send("MULTI")
-		rc.Send("SMEMBERS", partition)
-		rc.Send("DEL", partition)
-		reply, err := redis.Values(rc.Do("EXEC"))
+		prc.Send("MULTI")
+		prc.Send("SMEMBERS", partition)
+		prc.Send("DEL", partition)
+		reply, err := redis.Values(prc.Do("EXEC"))
 		if err != nil {
 			fmt.Printf("at=%q error=%s\n", "bucket-store-scan", err)
 			return
@@ -109,15 +222,161 @@ func (s *RedisStore) Scan(schedule time.Time) (<-chan *bucket.Bucket, error) {
 	return retBuckets, nil
 }
 
+// ScanAndFetch is Scan plus the LRANGE every caller used to issue
+// afterwards for each id. Instead of one round trip per bucket, ids in the
+// reclaimed partition are fetched in pipelined batches of fetchBatchSize
+// (defaultFetchBatchSize if <= 0) within a single MULTI/EXEC apiece, and
+// their Vals are decoded concurrently before the bucket is streamed out.
+// This turns an outlet's N+1 round trips per partition into a handful.
+func (s *RedisStore) ScanAndFetch(schedule time.Time, fetchBatchSize int) (<-chan *bucket.Bucket, error) {
+	if fetchBatchSize <= 0 {
+		fetchBatchSize = defaultFetchBatchSize
+	}
+	retBuckets := make(chan *bucket.Bucket)
+	rc, err := s.backend.Get(lockPrefix)
+	if err != nil {
+		return nil, err
+	}
+	mut := s.lockPartition(rc)
+	partition := partitionPrefix + "." + mut.Name
+	// Everything below — the SMEMBERS/DEL scan and every LRANGE fetchBatch
+	// pipelines — touches only partition-tagged keys (see storageKey), so
+	// one connection pinned to partition's slot serves this whole scan
+	// under clusterBackend.
+	prc, err := s.backend.Get(partition)
+	if err != nil {
+		rc.Close()
+		mut.Unlock(rc)
+		return nil, err
+	}
+	go func(out chan *bucket.Bucket) {
+		defer rc.Close()
+		defer prc.Close()
+		defer mut.Unlock(rc)
+		defer close(out)
+		prc.Send("MULTI")
+		prc.Send("SMEMBERS", partition)
+		prc.Send("DEL", partition)
+		reply, err := redis.Values(prc.Do("EXEC"))
+		if err != nil {
+			fmt.Printf("at=%q error=%s\n", "bucket-store-scan", err)
+			return
+		}
+		var delCount int64
+		var members []string
+		redis.Scan(reply, &members, &delCount)
+
+		ready := make([]*bucket.Id, 0, len(members))
+		for _, member := range members {
+			id := new(bucket.Id)
+			err := id.Decode(bytes.NewBufferString(member))
+			if err != nil {
+				fmt.Printf("at=%q error=%s\n",
+					"bucket-store-parse-key", err)
+				continue
+			}
+			bucketReady := id.Time.Add(id.Resolution)
+			if !bucketReady.After(schedule) {
+				ready = append(ready, id)
+			} else if err := s.putback(id); err != nil {
+				fmt.Printf("putback-error=%s\n", err)
+			}
+		}
+
+		for start := 0; start < len(ready); start += fetchBatchSize {
+			end := start + fetchBatchSize
+			if end > len(ready) {
+				end = len(ready)
+			}
+			s.fetchBatch(prc, partition, ready[start:end], out)
+		}
+	}(retBuckets)
+	return retBuckets, nil
+}
+
+// fetchBatch pipelines one LRANGE per id within a single MULTI/EXEC on rc,
+// decodes every reply's Vals concurrently, and streams the populated
+// buckets on out. Ids that fail to encode or decode are dropped, logged.
+// partition is the partition every id in ids was just reclaimed from; ids
+// are looked up via storageKey(partition, key) so every LRANGE in the
+// batch shares partition's hash tag and lands on the one slot rc is
+// already connected to, even under clusterBackend.
+func (s *RedisStore) fetchBatch(rc redis.Conn, partition string, ids []*bucket.Id, out chan *bucket.Bucket) {
+	keys := make([][]byte, len(ids))
+	rc.Send("MULTI")
+	for i, id := range ids {
+		key, err := id.Encode()
+		if err != nil {
+			fmt.Printf("at=%q error=%s\n", "bucket-store-fetch-encode", err)
+			continue
+		}
+		keys[i] = key
+		rc.Send("LRANGE", storageKey(partition, key), 0, -1)
+	}
+	replies, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		fmt.Printf("at=%q error=%s\n", "bucket-store-fetch", err)
+		return
+	}
+
+	buckets := make([]*bucket.Bucket, len(ids))
+	var wg sync.WaitGroup
+	replyIdx := 0
+	for i := range ids {
+		if keys[i] == nil {
+			continue
+		}
+		if replyIdx >= len(replies) {
+			break
+		}
+		reply := replies[replyIdx]
+		replyIdx++
+		wg.Add(1)
+		go func(i int, reply interface{}) {
+			defer wg.Done()
+			entries, err := redis.Values(reply, nil)
+			if err != nil || len(entries) == 0 {
+				return
+			}
+			b := &bucket.Bucket{Id: ids[i]}
+			// Every entry is a separate putValues call that had landed by
+			// the time this fetch ran; decode and append all of them
+			// instead of just the oldest, or everything but the first
+			// pending batch a CachedStore flush wrote is lost the moment
+			// this bucket falls out of the cache.
+			for _, entry := range entries {
+				raw, ok := entry.([]byte)
+				if !ok {
+					return
+				}
+				if err := decodeVals(raw, &b.Vals); err != nil {
+					fmt.Printf("at=%q error=%s\n", "bucket-store-fetch-decode", err)
+					return
+				}
+			}
+			buckets[i] = b
+		}(i, reply)
+	}
+	wg.Wait()
+	for _, b := range buckets {
+		if b != nil {
+			out <- b
+		}
+	}
+}
+
 func (s *RedisStore) putback(id *bucket.Id) error {
 	defer utils.MeasureT("bucket.putback", time.Now())
-	rc := s.redisPool.Get()
-	defer rc.Close()
 	key, err := id.Encode()
 	if err != nil {
 		return err
 	}
 	partition := s.bucketPartition(key)
+	rc, err := s.backend.Get(partition)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
 	rc.Send("MULTI")
 	rc.Send("SADD", partition, key)
 	rc.Send("EXPIRE", partition, 300)
@@ -131,9 +390,6 @@ func (s *RedisStore) putback(id *bucket.Id) error {
 func (s *RedisStore) Put(b *bucket.Bucket) error {
 	defer utils.MeasureT("bucket.put", time.Now())
 
-	rc := s.redisPool.Get()
-	defer rc.Close()
-
 	b.Lock()
 	key, err := b.Id.Encode()
 	value := b.Vals
@@ -141,11 +397,28 @@ func (s *RedisStore) Put(b *bucket.Bucket) error {
 	if err != nil {
 		return err
 	}
+	return s.putValues(key, value)
+}
 
+// putValues RPUSHes one or more already-aggregated value slices onto key
+// and registers key in its partition, all within a single MULTI/EXEC. Put
+// uses this with a single slice; CachedStore's flush uses it to push
+// several pending writes for the same bucket in one round trip.
+func (s *RedisStore) putValues(key []byte, values ...[]float64) error {
 	partition := s.bucketPartition(key)
+	skey := storageKey(partition, key)
+
+	rc, err := s.backend.Get(partition)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
 	rc.Send("MULTI")
-	rc.Send("RPUSH", key, value)
-	rc.Send("EXPIRE", key, 300)
+	for _, value := range values {
+		rc.Send("RPUSH", skey, encodeVals(value))
+	}
+	rc.Send("EXPIRE", skey, 300)
 	rc.Send("SADD", partition, key)
 	rc.Send("EXPIRE", partition, 300)
 	_, err = rc.Do("EXEC")
@@ -157,24 +430,33 @@ func (s *RedisStore) Put(b *bucket.Bucket) error {
 
 func (s *RedisStore) Get(b *bucket.Bucket) error {
 	defer utils.MeasureT("bucket.get", time.Now())
-	rc := s.redisPool.Get()
-	defer rc.Close()
-
 	key, err := b.Id.Encode()
 	if err != nil {
 		return err
 	}
-	reply, err := redis.Values(rc.Do("LRANGE", key, 0, -1))
+	partition := s.bucketPartition(key)
+	skey := storageKey(partition, key)
+
+	rc, err := s.backend.Get(partition)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reply, err := redis.Values(rc.Do("LRANGE", skey, 0, -1))
 	if err != nil {
 		return err
 	}
 	if len(reply) == 0 {
 		return errors.New("redis_store: Empty bucket.")
 	}
-	// The redis.Strings reply will always wrap our array in an outer
-	// array. Above, we checked that we would always have at least 1 elm.
-	if err := decodeList(reply[0].([]byte), &b.Vals); err != nil {
-		return err
+	// skey's list holds one element per putValues call that landed before
+	// this Get (CachedStore.flushEntry can push several at once), so every
+	// element has to be decoded and appended, not just the first.
+	for _, entry := range reply {
+		if err := decodeVals(entry.([]byte), &b.Vals); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -185,9 +467,19 @@ func (s *RedisStore) bucketPartition(b []byte) string {
 	return fmt.Sprintf("%s.%d", name, check%s.MaxPartitions())
 }
 
+// storageKey wraps a bucket's Redis key with its partition name as a
+// Cluster hash tag (`{partition}key`), so the bucket's list key and its
+// partition set always hash to the same slot. Every Put/Get touches
+// exactly one bucket + its partition, so tagging them together means the
+// MULTI/EXEC transactions below work unmodified against singleNodeBackend,
+// sentinelBackend, or clusterBackend alike.
+func storageKey(partition string, key []byte) []byte {
+	return []byte("{" + partition + "}" + string(key))
+}
+
 func (s *RedisStore) lockPartition(c redis.Conn) *redisync.Mutex {
 	for {
-		for p := uint64(0); p < s.MaxPartitions(); p++ {
+		for _, p := range s.ownedPartitions() {
 			name := fmt.Sprintf("%s.%d", lockPrefix, p)
 			mut := redisync.NewMutex(name, time.Minute)
 			if mut.TryLock(c) {
@@ -198,13 +490,54 @@ func (s *RedisStore) lockPartition(c redis.Conn) *redisync.Mutex {
 	}
 }
 
+// ownedPartitions lists the partitions this outlet should try to lock.
+// With a ring in place (RegisterOutlet was called), that's just the
+// partitions the consistent-hash ring assigns to this outlet's id, so
+// outlets only ever contend with each other over partitions they actually
+// share ownership of during a ring transition. Without a ring, or while
+// it hasn't heard from anyone yet, every partition is fair game, same as
+// before consistent-hash assignment existed.
+func (s *RedisStore) ownedPartitions() []uint64 {
+	s.outletMu.RLock()
+	id, ring := s.outletID, s.ring
+	s.outletMu.RUnlock()
+
+	if ring == nil {
+		return s.allPartitions()
+	}
+	var owned []uint64
+	for p := uint64(0); p < s.MaxPartitions(); p++ {
+		if ring.owner(p) == id {
+			owned = append(owned, p)
+		}
+	}
+	if len(owned) == 0 {
+		return s.allPartitions()
+	}
+	return owned
+}
+
+func (s *RedisStore) allPartitions() []uint64 {
+	all := make([]uint64, s.MaxPartitions())
+	for p := range all {
+		all[p] = uint64(p)
+	}
+	return all
+}
+
 func (s *RedisStore) flush() {
-	rc := s.redisPool.Get()
+	rc, err := s.backend.Get(lockPrefix)
+	if err != nil {
+		return
+	}
 	defer rc.Close()
 	rc.Do("FLUSHALL")
 }
 
-func decodeList(src []byte, dest *[]float64) error {
+// decodeLegacyList parses the pre-binary-codec text encoding: a Go-formatted
+// float slice such as "[1 2 3]". Kept only so one release of mixed-version
+// readers and writers can still read old entries; see codec.go.
+func decodeLegacyList(src []byte, dest *[]float64) error {
 	// Assume the array starts with '[' and ends with ']'
 	trimed := string(src[1:(len(src) - 1)])
 	// Assume the numbers are seperated by spaces.